@@ -0,0 +1,91 @@
+package undo
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrUnknownBookmark = errors.New("cannot go to bookmark - no bookmark with this name")
+var ErrBookmarkInvalid = errors.New("cannot go to bookmark - the bookmarked position is no longer in the history")
+
+// bookmark is a named position in the history.
+type bookmark struct {
+	name string
+	pos  int // absolute position like savedPos, -1 if it can't be reached
+}
+
+// Bookmark records the current position under the given name, replacing an existing bookmark
+// with the same name. A bookmark becomes invalid when the operation it points at is evicted, or
+// when an operation is added after undoing past it.
+func (mgr *UndoManager) Bookmark(name string) {
+	mgr.mutex.Lock()
+	mgr.setBookmark(name)
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseBookmark, name, nil)
+}
+
+// setBookmark records the current position under the given name. The mutex must be held.
+func (mgr *UndoManager) setBookmark(name string) {
+	for i := range mgr.bookmarks {
+		if mgr.bookmarks[i].name == name {
+			mgr.bookmarks[i].pos = mgr.position()
+			return
+		}
+	}
+	mgr.bookmarks = append(mgr.bookmarks, bookmark{name: name, pos: mgr.position()})
+}
+
+// Bookmarks returns the names of all bookmarks, including invalid ones, in the order in which
+// they were first created.
+func (mgr *UndoManager) Bookmarks() []string {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	result := make([]string, len(mgr.bookmarks))
+	for i := range mgr.bookmarks {
+		result[i] = mgr.bookmarks[i].name
+	}
+	return result
+}
+
+// GoToBookmark undoes or redoes operations like GoTo until the position recorded by the named
+// bookmark is reached. It returns ErrUnknownBookmark if there is no such bookmark and
+// ErrBookmarkInvalid if the bookmarked position can no longer be reached.
+func (mgr *UndoManager) GoToBookmark(ctx context.Context, name string) error {
+	mgr.mutex.RLock()
+	n, err := mgr.bookmarkPosition(name)
+	mgr.mutex.RUnlock()
+	if err == nil {
+		err = mgr.goTo(ctx, n)
+	}
+	mgr.logEvent(PhaseGoTo, name, err)
+	return err
+}
+
+// bookmarkPosition returns the position of the named bookmark relative to the bottom of the undo
+// stack. The mutex must be held.
+func (mgr *UndoManager) bookmarkPosition(name string) (int, error) {
+	for _, b := range mgr.bookmarks {
+		if b.name != name {
+			continue
+		}
+		n := b.pos - mgr.dropped
+		if b.pos < 0 || n < 0 || n > len(mgr.undoStack)+len(mgr.redoStack) {
+			return 0, ErrBookmarkInvalid
+		}
+		return n, nil
+	}
+	return 0, ErrUnknownBookmark
+}
+
+// invalidateAfter invalidates the save marker and all bookmarks that point beyond pos, because
+// the history from there on is about to be replaced. The mutex must be held.
+func (mgr *UndoManager) invalidateAfter(pos int) {
+	if mgr.savedPos > pos {
+		mgr.savedPos = -1
+	}
+	for i := range mgr.bookmarks {
+		if mgr.bookmarks[i].pos > pos {
+			mgr.bookmarks[i].pos = -1
+		}
+	}
+}
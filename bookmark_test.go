@@ -0,0 +1,88 @@
+package undo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rasteric/undo"
+)
+
+func TestGoToBookmarkBackAndForward(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	mgr.Bookmark("one")
+	mustAdd(t, mgr, "b")
+	mustAdd(t, mgr, "c")
+	mgr.Bookmark("three")
+	if err := mgr.GoToBookmark(ctx, "one"); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Position() != 1 {
+		t.Errorf("Position() = %d after jumping back, want 1", mgr.Position())
+	}
+	if err := mgr.GoToBookmark(ctx, "three"); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Position() != 3 {
+		t.Errorf("Position() = %d after jumping forward, want 3", mgr.Position())
+	}
+	assertNames(t, mgr.Bookmarks(), "one", "three")
+}
+
+func TestGoToBookmarkInvalid(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 2})
+	ctx := context.Background()
+	if err := mgr.GoToBookmark(ctx, "missing"); !errors.Is(err, undo.ErrUnknownBookmark) {
+		t.Errorf("GoToBookmark() = %v, want ErrUnknownBookmark", err)
+	}
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	mgr.Bookmark("replaced")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, mgr, "c")
+	if err := mgr.GoToBookmark(ctx, "replaced"); !errors.Is(err, undo.ErrBookmarkInvalid) {
+		t.Errorf("GoToBookmark() = %v after replacing its history, want ErrBookmarkInvalid", err)
+	}
+	mgr.Bookmark("evicted")
+	mustAdd(t, mgr, "d")
+	mustAdd(t, mgr, "e")
+	mustAdd(t, mgr, "f")
+	if err := mgr.GoToBookmark(ctx, "evicted"); !errors.Is(err, undo.ErrBookmarkInvalid) {
+		t.Errorf("GoToBookmark() = %v after eviction, want ErrBookmarkInvalid", err)
+	}
+}
+
+func TestMarksAroundDroppedOp(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	if err := mgr.Add("x", nop, nil); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mgr.MarkSaved()
+	mgr.Bookmark("ax")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Bookmark("a")
+	if err := mgr.Redo(ctx); !errors.Is(err, undo.ErrCantRedo) {
+		t.Fatalf("Redo() = %v after dropping x, want ErrCantRedo", err)
+	}
+	if !mgr.IsModified() {
+		t.Error("IsModified() = false after dropping the saved op, want true")
+	}
+	if err := mgr.GoToBookmark(ctx, "ax"); !errors.Is(err, undo.ErrBookmarkInvalid) {
+		t.Errorf("GoToBookmark() = %v for a dropped op, want ErrBookmarkInvalid", err)
+	}
+	if err := mgr.GoToBookmark(ctx, "a"); err != nil {
+		t.Errorf("GoToBookmark() = %v before the dropped op, want nil", err)
+	}
+}
@@ -0,0 +1,21 @@
+package undo
+
+import "time"
+
+// Clock is the source of time used by an UndoManager. Tests can supply a fake clock in the
+// Config to control time-based behavior precisely; see the undotest package.
+type Clock interface {
+	Now() time.Time                         // the current time
+	After(d time.Duration) <-chan time.Time // a channel that receives the time once d has elapsed
+}
+
+// realClock is the default Clock based on the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,105 @@
+package undo
+
+import "sync"
+
+// Cursor is a lightweight view position into the combined timeline of an UndoManager. The timeline
+// consists of the undo stack from oldest to newest followed by the redo stack from next to last,
+// so the manager's own position is the length of the undo stack. Several cursors may share one
+// manager, for example one per editor window, and moving one cursor never affects another cursor
+// or the manager's stacks.
+//
+// A cursor does not hold on to any entries. When the oldest entries are evicted because of the
+// storage limit, a cursor keeps pointing at the same entry, or at the beginning of the timeline if
+// that entry was evicted itself. Adding an operation discards the redo stack, so the timeline
+// after the manager's position is replaced by the new entry; a cursor that pointed into the
+// discarded part is clamped to the new end of the timeline. Both adjustments happen the next time
+// the cursor is read or moved.
+type Cursor struct {
+	mgr   *UndoManager
+	mutex sync.Mutex
+	pos   int // position counted from the first entry ever added, including evicted ones
+}
+
+// NewCursor returns a new cursor positioned at the manager's current position.
+func (mgr *UndoManager) NewCursor() *Cursor {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return &Cursor{mgr: mgr, pos: mgr.position()}
+}
+
+// bounds returns the number of evicted entries and the length of the combined timeline.
+func (c *Cursor) bounds() (int, int) {
+	c.mgr.mutex.RLock()
+	defer c.mgr.mutex.RUnlock()
+	return c.mgr.dropped, len(c.mgr.undoStack) + len(c.mgr.redoStack)
+}
+
+// clamp makes sure the cursor's position lies within the timeline and returns the cursor's index
+// into the timeline together with the timeline length. The cursor's mutex must be held.
+func (c *Cursor) clamp() (int, int) {
+	base, n := c.bounds()
+	if c.pos < base {
+		c.pos = base
+	}
+	if c.pos > base+n {
+		c.pos = base + n
+	}
+	return c.pos - base, n
+}
+
+// Position returns the cursor's index into the combined timeline.
+func (c *Cursor) Position() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pos, _ := c.clamp()
+	return pos
+}
+
+// UndoVisible returns true if an entry lies before the cursor, false otherwise.
+func (c *Cursor) UndoVisible() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pos, _ := c.clamp()
+	return pos > 0
+}
+
+// RedoVisible returns true if an entry lies after the cursor, false otherwise.
+func (c *Cursor) RedoVisible() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pos, n := c.clamp()
+	return pos < n
+}
+
+// Back moves the cursor one entry towards the beginning of the timeline. It returns false if
+// the cursor was already at the beginning.
+func (c *Cursor) Back() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if pos, _ := c.clamp(); pos == 0 {
+		return false
+	}
+	c.pos--
+	return true
+}
+
+// Forward moves the cursor one entry towards the end of the timeline. It returns false if
+// the cursor was already at the end.
+func (c *Cursor) Forward() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if pos, n := c.clamp(); pos >= n {
+		return false
+	}
+	c.pos++
+	return true
+}
+
+// Sync moves the cursor to the manager's current position.
+func (c *Cursor) Sync() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.mgr.mutex.RLock()
+	defer c.mgr.mutex.RUnlock()
+	c.pos = c.mgr.position()
+}
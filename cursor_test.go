@@ -0,0 +1,92 @@
+package undo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rasteric/undo"
+)
+
+func TestCursorMovesWithoutChangingStacks(t *testing.T) {
+	mgr := newManager(t)
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	c := mgr.NewCursor()
+	if c.Position() != 3 || c.RedoVisible() {
+		t.Fatalf("new cursor at %d, RedoVisible %v; want 3, false", c.Position(), c.RedoVisible())
+	}
+	if !c.Back() || !c.Back() || c.Position() != 1 {
+		t.Fatalf("cursor at %d after moving back twice, want 1", c.Position())
+	}
+	if mgr.Position() != 3 {
+		t.Errorf("manager position %d after moving the cursor, want 3", mgr.Position())
+	}
+	c.Sync()
+	if c.Position() != 3 || c.Forward() {
+		t.Errorf("cursor at %d after Sync, want 3 at the end of the timeline", c.Position())
+	}
+}
+
+func TestCursorClampedWhenRedoDiscarded(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	c := mgr.NewCursor()
+	if err := mgr.GoTo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if c.Position() != 3 {
+		t.Fatalf("cursor at %d after undoing, want 3", c.Position())
+	}
+	mustAdd(t, mgr, "d")
+	if c.Position() != 2 || c.RedoVisible() {
+		t.Errorf("cursor at %d, RedoVisible %v after Add; want 2, false", c.Position(), c.RedoVisible())
+	}
+}
+
+func TestCursorsAreIndependent(t *testing.T) {
+	mgr := newManager(t)
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	first, second := mgr.NewCursor(), mgr.NewCursor()
+	first.Back()
+	first.Back()
+	if first.Position() != 1 || second.Position() != 3 {
+		t.Errorf("cursors at %d and %d after moving the first, want 1 and 3", first.Position(),
+			second.Position())
+	}
+	second.Back()
+	if first.Position() != 1 || second.Position() != 2 {
+		t.Errorf("cursors at %d and %d after moving the second, want 1 and 2", first.Position(),
+			second.Position())
+	}
+}
+
+func TestCursorAcrossEvictionAndClear(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 3})
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	afterA, afterB := mgr.NewCursor(), mgr.NewCursor()
+	afterA.Back()
+	afterA.Back()
+	afterB.Back()
+	mustAdd(t, mgr, "d")
+	if afterA.Position() != 0 || afterA.UndoVisible() || !afterA.RedoVisible() {
+		t.Errorf("cursor after evicted entry at %d, UndoVisible %v, RedoVisible %v; want 0, false, true",
+			afterA.Position(), afterA.UndoVisible(), afterA.RedoVisible())
+	}
+	if afterB.Position() != 1 || !afterB.UndoVisible() || !afterB.RedoVisible() {
+		t.Errorf("cursor after kept entry at %d, UndoVisible %v, RedoVisible %v; want 1, true, true",
+			afterB.Position(), afterB.UndoVisible(), afterB.RedoVisible())
+	}
+	mgr.Clear()
+	if afterB.Position() != 0 || afterB.UndoVisible() || afterB.RedoVisible() {
+		t.Errorf("cursor after Clear at %d, UndoVisible %v, RedoVisible %v; want 0, false, false",
+			afterB.Position(), afterB.UndoVisible(), afterB.RedoVisible())
+	}
+}
@@ -0,0 +1,109 @@
+package undo
+
+import (
+	"sync"
+	"time"
+)
+
+// Phase identifies the kind of manager action recorded in a LogEntry.
+type Phase int
+
+const (
+	PhaseAdd       Phase = iota // an undo function was added
+	PhaseUndo                   // an operation was undone
+	PhaseRedo                   // an operation was redone
+	PhaseReplace                // the top undo entry was replaced
+	PhaseClear                  // the stacks were cleared
+	PhaseReset                  // the manager was reset
+	PhaseGoTo                   // GoTo or GoToBookmark moved through the history
+	PhaseMarkSaved              // the current position was marked as saved
+	PhaseBookmark               // a bookmark was set
+)
+
+// String returns a short lowercase name of the phase.
+func (p Phase) String() string {
+	switch p {
+	case PhaseAdd:
+		return "add"
+	case PhaseUndo:
+		return "undo"
+	case PhaseRedo:
+		return "redo"
+	case PhaseReplace:
+		return "replace"
+	case PhaseClear:
+		return "clear"
+	case PhaseReset:
+		return "reset"
+	case PhaseGoTo:
+		return "goto"
+	case PhaseMarkSaved:
+		return "marksaved"
+	case PhaseBookmark:
+		return "bookmark"
+	}
+	return "unknown"
+}
+
+// LogEntry is a single entry of the event log returned by DumpEventLog.
+type LogEntry struct {
+	Time  time.Time // when the action completed
+	Name  string    // the name of the operation or bookmark, "" if there was none
+	Phase Phase     // the kind of action
+	Err   error     // the outcome of the action, nil on success
+}
+
+// eventLog is a bounded ring buffer of log entries.
+type eventLog struct {
+	mutex   sync.Mutex
+	entries []LogEntry // the ring buffer, allocated with the configured size
+	next    int        // index of the slot that is written next
+	full    bool       // true once the buffer has wrapped around
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{entries: make([]LogEntry, size)}
+}
+
+// record stores an entry, overwriting the oldest one if the buffer is full.
+func (l *eventLog) record(entry LogEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries[l.next] = entry
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// dump returns a copy of the entries from oldest to newest.
+func (l *eventLog) dump() []LogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.full {
+		result := make([]LogEntry, l.next)
+		copy(result, l.entries[:l.next])
+		return result
+	}
+	result := make([]LogEntry, 0, len(l.entries))
+	result = append(result, l.entries[l.next:]...)
+	return append(result, l.entries[:l.next]...)
+}
+
+// logEvent records an action in the event log if it is enabled.
+func (mgr *UndoManager) logEvent(phase Phase, name string, err error) {
+	if mgr.events != nil {
+		mgr.events.record(LogEntry{Time: mgr.clock.Now(), Name: name, Phase: phase, Err: err})
+	}
+}
+
+// DumpEventLog returns the entries of the event log from oldest to newest. At most
+// Config.EventLogSize entries are kept; older ones are overwritten. If the event log is disabled,
+// an empty slice is returned.
+func (mgr *UndoManager) DumpEventLog() []LogEntry {
+	if mgr.events == nil {
+		return []LogEntry{}
+	}
+	return mgr.events.dump()
+}
@@ -0,0 +1,62 @@
+package undo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rasteric/undo"
+)
+
+func TestEventLogWrapsAround(t *testing.T) {
+	mgr := newManager(t, undo.Config{EventLogSize: 3})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		mustAdd(t, mgr, name)
+	}
+	entries := mgr.DumpEventLog()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	for i, want := range []string{"c", "d", "e"} {
+		if entries[i].Name != want || entries[i].Phase != undo.PhaseAdd {
+			t.Errorf("entry %d = %q %v, want %q add", i, entries[i].Name, entries[i].Phase, want)
+		}
+	}
+}
+
+func TestEventLogRecordsHistoryActions(t *testing.T) {
+	mgr := newManager(t, undo.Config{EventLogSize: 16})
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	mgr.MarkSaved()
+	mgr.Bookmark("mark")
+	if err := mgr.GoTo(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.GoToBookmark(ctx, "mark"); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Clear()
+	mgr.Reset()
+	want := []undo.Phase{undo.PhaseAdd, undo.PhaseMarkSaved, undo.PhaseBookmark, undo.PhaseUndo,
+		undo.PhaseGoTo, undo.PhaseRedo, undo.PhaseGoTo, undo.PhaseClear, undo.PhaseReset}
+	entries := mgr.DumpEventLog()
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %v", len(entries), len(want), entries)
+	}
+	for i := range want {
+		if entries[i].Phase != want[i] {
+			t.Errorf("entry %d has phase %v, want %v", i, entries[i].Phase, want[i])
+		}
+	}
+	if entries[6].Name != "mark" {
+		t.Errorf("GoToBookmark logged name %q, want %q", entries[6].Name, "mark")
+	}
+}
+
+func TestEventLogDisabled(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	if entries := mgr.DumpEventLog(); entries == nil || len(entries) != 0 {
+		t.Errorf("DumpEventLog() = %v, want empty slice", entries)
+	}
+}
@@ -0,0 +1,191 @@
+package undo
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoOpenGroup = errors.New("cannot end group - no group has been started")
+var ErrNilUndo = errors.New("cannot add batch - an undo function is nil")
+
+// group collects the operations added between BeginGroup and EndGroup into a single composite op.
+// A group remembers how many of its operations have been undone, so that undoing or redoing it
+// can be resumed after one of its functions failed.
+type group struct {
+	name   string
+	ops    []op // the collected operations in the order in which they were added
+	undone int  // the number of operations at the end of ops that are currently undone
+}
+
+// undo undoes the operations of the group that are not yet undone in reverse order. Groups
+// yield no value.
+func (g *group) undo(ctx context.Context) (any, error) {
+	for g.undone < len(g.ops) {
+		if _, err := g.ops[len(g.ops)-1-g.undone].fn(ctx); err != nil {
+			return nil, err
+		}
+		g.undone++
+	}
+	return nil, nil
+}
+
+// redo redoes the undone operations of the group in forward order. Groups yield no value.
+func (g *group) redo(ctx context.Context) (any, error) {
+	for g.undone > 0 {
+		if _, err := g.ops[len(g.ops)-g.undone].redoFn(ctx); err != nil {
+			return nil, err
+		}
+		g.undone--
+	}
+	return nil, nil
+}
+
+// op returns the composite op of the group. The group can only be redone if all of its
+// operations can be redone, and it is strict if any of its operations is strict.
+func (g *group) op() op {
+	o := op{name: g.name, fn: g.undo, redoFn: g.redo, group: g}
+	for i := range g.ops {
+		if g.ops[i].redoFn == nil {
+			o.redoFn = nil
+		}
+		if g.ops[i].strict {
+			o.strict = true
+		}
+	}
+	return o
+}
+
+// BeginGroup starts a group with the given name. All operations added until the matching call
+// to EndGroup are collected into a single entry, which is undone and redone as a whole and whose
+// name is reported by UndoName and RedoName. Groups may be nested; an inner group becomes one
+// entry of the enclosing group.
+//
+// If an undo or redo function of a group returns an error, the group stops and stays on its stack,
+// so calling Undo or Redo again resumes with the function that failed.
+func (mgr *UndoManager) BeginGroup(name string) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.groups = append(mgr.groups, &group{name: name})
+}
+
+// EndGroup ends the group started by the last call to BeginGroup and adds it to the UndoManager.
+// A group without operations is discarded. If no group has been started, ErrNoOpenGroup is
+// returned. If the group contains an operation added with TryAdd and does not fit into the limits,
+// it is discarded and a StorageError is returned. If the UndoManager has been shut down, the group
+// is discarded and ErrManagerClosed is returned.
+func (mgr *UndoManager) EndGroup() error {
+	mgr.mutex.Lock()
+	if len(mgr.groups) == 0 {
+		mgr.mutex.Unlock()
+		return ErrNoOpenGroup
+	}
+	g := mgr.groups[len(mgr.groups)-1]
+	mgr.groups = mgr.groups[:len(mgr.groups)-1]
+	if mgr.closed() {
+		mgr.mutex.Unlock()
+		mgr.logEvent(PhaseAdd, g.name, ErrManagerClosed)
+		return ErrManagerClosed
+	}
+	if len(g.ops) == 0 {
+		mgr.mutex.Unlock()
+		return nil
+	}
+	if !mgr.push(g.op()) {
+		mgr.mutex.Unlock()
+		err := StorageError{Name: g.name}
+		mgr.logEvent(PhaseAdd, g.name, err)
+		return err
+	}
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseAdd, g.name, nil)
+	notify(subscribers)
+	return nil
+}
+
+// UndoRedoPair is an undo function together with the function that redoes it, for use with
+// AddBatch.
+type UndoRedoPair struct {
+	Undo func(ctx context.Context) error
+	Redo func(ctx context.Context) error
+}
+
+// AddBatch adds several undo/redo pairs as a single entry with the given name under one lock
+// acquisition, so concurrent readers never observe a partially built entry. Undoing the entry
+// runs the undo functions in reverse order, redoing it runs the redo functions in forward order,
+// just like a group. An empty batch is ignored. A pair may lack its Redo function, which makes the
+// entry impossible to redo, but not its Undo function; AddBatch returns ErrNilUndo and adds nothing
+// in that case. AddBatch returns ErrManagerClosed if the UndoManager has been shut down.
+func (mgr *UndoManager) AddBatch(name string, entries []UndoRedoPair) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	g := &group{name: name, ops: make([]op, len(entries))}
+	for i, e := range entries {
+		if e.Undo == nil {
+			mgr.logEvent(PhaseAdd, name, ErrNilUndo)
+			return ErrNilUndo
+		}
+		g.ops[i] = op{name: name, fn: wrap(e.Undo), redoFn: wrap(e.Redo)}
+	}
+	return mgr.add(g.op())
+}
+
+// push adds an op to the innermost open group or, if there is none, to the undo stack, evicting
+// the oldest entry if the storage limit is reached, or merges it into the top entry. Pushing onto
+// the undo stack discards the redo stack. While undo or redo functions are running, the op is
+// queued instead. A strict op that would evict an entry is rejected, and push returns false;
+// otherwise it returns true. The op's timestamps are set when it is first pushed. The mutex must
+// be held.
+func (mgr *UndoManager) push(o op) bool {
+	if o.added.IsZero() {
+		o.added = mgr.clock.Now()
+		o.last = o.added
+	}
+	if len(mgr.groups) > 0 {
+		g := mgr.groups[len(mgr.groups)-1]
+		g.ops = append(g.ops, o)
+		return true
+	}
+	if mgr.running > 0 {
+		mgr.pending = append(mgr.pending, o)
+		return true
+	}
+	if o.strict && !mgr.mergeable(o) && mgr.full() {
+		return false
+	}
+	mgr.redoStack = make([]op, 0)
+	if mgr.merge(o) {
+		return true
+	}
+	mgr.invalidateAfter(mgr.position())
+	mgr.evict(1)
+	mgr.undoStack = append(mgr.undoStack, o)
+	return true
+}
+
+// merge merges o into the top of the undo stack if merging is enabled, both have the same name,
+// neither is a group, and the top was last added to within the merge window. The merged entry
+// keeps the top's undo function and takes o's redo function, so undoing it reverts to the state
+// before the first merged operation and redoing it restores the state after the last one. It
+// returns true if o was merged. The mutex must be held.
+func (mgr *UndoManager) merge(o op) bool {
+	if !mgr.mergeable(o) {
+		return false
+	}
+	top := &mgr.undoStack[len(mgr.undoStack)-1]
+	top.redoFn = o.redoFn
+	top.last = o.last
+	mgr.invalidateAfter(mgr.position() - 1)
+	return true
+}
+
+// mergeable returns true if merge would merge o into the top of the undo stack. The mutex must be
+// held.
+func (mgr *UndoManager) mergeable(o op) bool {
+	if mgr.config.MergeWindow <= 0 || len(mgr.undoStack) == 0 || o.group != nil {
+		return false
+	}
+	top := mgr.undoStack[len(mgr.undoStack)-1]
+	return top.group == nil && top.name == o.name && o.last.Sub(top.last) <= mgr.config.MergeWindow
+}
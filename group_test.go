@@ -0,0 +1,137 @@
+package undo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rasteric/undo"
+)
+
+// recorder records the names of undo and redo functions in the order in which they run.
+type recorder struct {
+	calls []string
+	fail  map[string]error // errors to return once from the function with the given name
+}
+
+// fn returns a function that records name and returns the error registered for it, if any.
+func (r *recorder) fn(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r.calls = append(r.calls, name)
+		if err := r.fail[name]; err != nil {
+			delete(r.fail, name)
+			return err
+		}
+		return nil
+	}
+}
+
+// add adds an operation whose functions record "undo <name>" and "redo <name>".
+func (r *recorder) add(t *testing.T, mgr *undo.UndoManager, name string) {
+	t.Helper()
+	if err := mgr.Add(name, r.fn("undo "+name), r.fn("redo "+name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupOfThree(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	r := &recorder{}
+	mgr.BeginGroup("g")
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.EndGroup(); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.UndoNames(), "g")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo c", "undo b", "undo a", "redo a", "redo b", "redo c")
+}
+
+func TestNestedGroup(t *testing.T) {
+	mgr := newManager(t)
+	r := &recorder{}
+	mgr.BeginGroup("outer")
+	r.add(t, mgr, "a")
+	mgr.BeginGroup("inner")
+	r.add(t, mgr, "b")
+	r.add(t, mgr, "c")
+	if err := mgr.EndGroup(); err != nil {
+		t.Fatal(err)
+	}
+	r.add(t, mgr, "d")
+	if err := mgr.EndGroup(); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.UndoNames(), "outer")
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo d", "undo c", "undo b", "undo a")
+	if err := mgr.EndGroup(); !errors.Is(err, undo.ErrNoOpenGroup) {
+		t.Errorf("EndGroup() = %v, want ErrNoOpenGroup", err)
+	}
+}
+
+func TestGroupUndoFailsPartway(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	failure := errors.New("failure")
+	r := &recorder{fail: map[string]error{"undo b": failure}}
+	mgr.BeginGroup("g")
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.EndGroup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Undo(ctx); !errors.Is(err, failure) {
+		t.Fatalf("Undo() = %v, want %v", err, failure)
+	}
+	if mgr.UndoName() != "g" || mgr.CanRedo() {
+		t.Fatalf("UndoName() = %q, CanRedo() = %v, want the group back on the undo stack",
+			mgr.UndoName(), mgr.CanRedo())
+	}
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo c", "undo b", "undo b", "undo a")
+	assertNames(t, mgr.RedoNames(), "g")
+}
+
+func TestAddBatch(t *testing.T) {
+	mgr := newManager(t)
+	r := &recorder{}
+	mustAdd(t, mgr, "before")
+	err := mgr.AddBatch("batch", []undo.UndoRedoPair{
+		{Undo: r.fn("undo a"), Redo: r.fn("redo a")},
+		{Undo: r.fn("undo b"), Redo: r.fn("redo b")},
+		{Undo: r.fn("undo c"), Redo: r.fn("redo c")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.UndoNames(), "batch", "before")
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo c", "undo b", "undo a")
+}
+
+func TestAddBatchRejectsNilUndo(t *testing.T) {
+	mgr := newManager(t)
+	err := mgr.AddBatch("batch", []undo.UndoRedoPair{{Undo: nop, Redo: nop}, {Redo: nop}})
+	if !errors.Is(err, undo.ErrNilUndo) {
+		t.Fatalf("AddBatch() = %v, want ErrNilUndo", err)
+	}
+	if mgr.CanUndo() {
+		t.Errorf("AddBatch added %q despite the error", mgr.UndoNames())
+	}
+}
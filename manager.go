@@ -0,0 +1,58 @@
+package undo
+
+import (
+	"context"
+	"time"
+)
+
+// Manager is the interface implemented by UndoManager. Code that records and undoes operations
+// can depend on Manager instead of *UndoManager, so tests can substitute a fake. It contains every
+// exported method of UndoManager except NewCursor, because a Cursor is tied to an UndoManager.
+type Manager interface {
+	Add(name string, undoFn func(ctx context.Context) error, redoFn func(ctx context.Context) error) error
+	AddV(name string, undoFn func(ctx context.Context) (any, error),
+		redoFn func(ctx context.Context) (any, error)) error
+	TryAdd(name string, undoFn func(ctx context.Context) error, redoFn func(ctx context.Context) error) error
+	AddBatch(name string, entries []UndoRedoPair) error
+	Replace(name string, undoFn func(ctx context.Context) error, redoFn func(ctx context.Context) error) bool
+	BeginGroup(name string)
+	EndGroup() error
+	Undo(ctx context.Context) error
+	UndoV(ctx context.Context) (any, error)
+	Redo(ctx context.Context) error
+	RedoV(ctx context.Context) (any, error)
+	UndoAll(ctx context.Context) error
+	RedoAll(ctx context.Context) error
+	GoTo(ctx context.Context, n int) error
+	Position() int
+	CanUndo() bool
+	CanRedo() bool
+	UndoName() string
+	RedoName() string
+	UndoNames() []string
+	RedoNames() []string
+	Entries() []HistoryEntry
+	State() State
+	Capacity() int
+	Used() int
+	Clear()
+	Reset()
+	Subscribe(fn func()) func()
+	MarkSaved()
+	IsModified() bool
+	Bookmark(name string)
+	Bookmarks() []string
+	GoToBookmark(ctx context.Context, name string) error
+	DumpEventLog() []LogEntry
+	WithCancel() (context.Context, func())
+	WithValue(key, val any) (context.Context, func())
+	Context() context.Context
+	WGAdd(n int)
+	CancelAll()
+	WaitAll()
+	Idle() <-chan struct{}
+	Shutdown(cancel bool)
+	ShutdownWithTimeout(cancel bool, d time.Duration) error
+}
+
+var _ Manager = (*UndoManager)(nil)
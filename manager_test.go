@@ -0,0 +1,49 @@
+package undo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rasteric/undo"
+)
+
+// fakeManager is a trivial Manager that only counts calls of Add and Undo. Calling any other
+// method panics.
+type fakeManager struct {
+	undo.Manager
+	adds, undos int
+}
+
+func (m *fakeManager) Add(name string, undoFn func(ctx context.Context) error,
+	redoFn func(ctx context.Context) error) error {
+	m.adds++
+	return nil
+}
+
+func (m *fakeManager) Undo(ctx context.Context) error {
+	m.undos++
+	return nil
+}
+
+// rename stands for code that depends on Manager rather than *UndoManager.
+func rename(mgr undo.Manager, ctx context.Context) error {
+	if err := mgr.Add("rename", nop, nop); err != nil {
+		return err
+	}
+	return mgr.Undo(ctx)
+}
+
+func TestManagerCanBeFaked(t *testing.T) {
+	fake := &fakeManager{}
+	if err := rename(fake, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.adds != 1 || fake.undos != 1 {
+		t.Errorf("fake saw %d adds and %d undos, want 1 and 1", fake.adds, fake.undos)
+	}
+	mgr := newManager(t)
+	if err := rename(mgr, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.RedoNames(), "rename")
+}
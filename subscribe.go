@@ -0,0 +1,52 @@
+package undo
+
+// subscriber is a function registered with Subscribe.
+type subscriber struct {
+	id int
+	fn func()
+}
+
+// Subscribe registers fn to be called whenever the result of CanUndo or CanRedo changes, e.g. to
+// enable or disable menu items. fn receives no arguments and should re-read the state it needs.
+// It is called after the change is complete and without holding the UndoManager's lock, so it may
+// call back into the UndoManager. Subscribers are called in registration order. The returned
+// function removes the subscription.
+func (mgr *UndoManager) Subscribe(fn func()) func() {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.nextSubID++
+	id := mgr.nextSubID
+	mgr.subscribers = append(mgr.subscribers, subscriber{id: id, fn: fn})
+	return func() {
+		mgr.mutex.Lock()
+		defer mgr.mutex.Unlock()
+		for i := range mgr.subscribers {
+			if mgr.subscribers[i].id == id {
+				mgr.subscribers = append(mgr.subscribers[:i:i], mgr.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// observe checks whether CanUndo or CanRedo changed since the last check and, if so, returns the
+// functions of the current subscribers. It returns nil otherwise. The mutex must be held.
+func (mgr *UndoManager) observe() []func() {
+	avail := [2]bool{len(mgr.undoStack) > 0, len(mgr.redoStack) > 0}
+	if avail == mgr.avail {
+		return nil
+	}
+	mgr.avail = avail
+	fns := make([]func(), len(mgr.subscribers))
+	for i := range mgr.subscribers {
+		fns[i] = mgr.subscribers[i].fn
+	}
+	return fns
+}
+
+// notify calls the functions returned by observe. The mutex must not be held.
+func notify(fns []func()) {
+	for _, fn := range fns {
+		fn()
+	}
+}
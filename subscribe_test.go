@@ -0,0 +1,28 @@
+package undo_test
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTwoSubscribers(t *testing.T) {
+	mgr := newManager(t)
+	var first, second int
+	mgr.Subscribe(func() { first++ })
+	unsubscribe := mgr.Subscribe(func() { second++ })
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first != 2 || second != 2 {
+		t.Errorf("subscribers called %d and %d times, want 2 and 2", first, second)
+	}
+	unsubscribe()
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first != 3 || second != 2 {
+		t.Errorf("subscribers called %d and %d times after unsubscribing, want 3 and 2", first, second)
+	}
+}
@@ -3,44 +3,131 @@ package undo
 import (
 	"context"
 	"errors"
+	"strconv"
 	"sync"
+	"time"
 )
 
 var ErrOutOfMemory = errors.New("command storage limit exceeded; try to increase the undo/redo limit")
 var ErrTooManyConfig = errors.New("only one optional configuration argument can be passed to UndoManager")
 var ErrCantUndo = errors.New("cannot undo operation - nothing to undo")
 var ErrCantRedo = errors.New("cannot redo operation - nothing to redo")
+var ErrShutdownTimeout = errors.New("shutdown timed out - operations did not finish in time")
+var ErrManagerClosed = errors.New("cannot add, undo or redo - the undo manager has been shut down")
+var ErrRateLimited = errors.New("cannot undo or redo - called again too quickly")
+var ErrInvalidPosition = errors.New("cannot go to position - position is outside of the history")
 
-// UnlimitedStorage is an option for NewCmdMgr that allows for unlimited storage.
+// errPanicked is the outcome recorded for an undo or redo function that panicked.
+var errPanicked = errors.New("undo or redo function panicked")
+
+// StorageError is returned by TryAdd when an operation does not fit into the storage limit. It
+// wraps ErrOutOfMemory, so errors.Is(err, ErrOutOfMemory) holds, and records the name of the
+// rejected operation.
+type StorageError struct {
+	Name string // the name of the operation that could not be added
+}
+
+func (e StorageError) Error() string {
+	return ErrOutOfMemory.Error() + " (adding " + strconv.Quote(e.Name) + ")"
+}
+
+// Unwrap returns ErrOutOfMemory.
+func (e StorageError) Unwrap() error {
+	return ErrOutOfMemory
+}
+
+// UnlimitedStorage is a Config.StorageLimit value that allows for unlimited storage.
 const UnlimitedStorage = 0
 
-// Config represents a CmdMgr configuration.
+// Config represents an UndoManager configuration. The storage, undo and redo limits are enforced
+// independently of each other; when a limit is reached the oldest entries are dropped.
 type Config struct {
-	StorageLimit int
+	StorageLimit    int           // maximum combined size of the undo and redo stacks, 0 for unlimited
+	UndoLimit       int           // maximum size of the undo stack, 0 for unlimited
+	RedoLimit       int           // maximum size of the redo stack, 0 for unlimited
+	EventLogSize    int           // number of entries kept by the event log, 0 disables it
+	Clock           Clock         // the source of time, nil for the real time
+	MergeWindow     time.Duration // maximum time between Adds with the same name to merge them, 0 disables it
+	MinUndoInterval time.Duration // minimum time between two calls of Undo or Redo, 0 disables it
 }
 
-// Defaults represents the default configuration of an OpManager. Use the Defaults as a starting
-// point for modifications instead of an empty Config.
+// Defaults represents the default configuration of an UndoManager. New uses it when no Config is
+// passed.
+//
+// Deprecated: Modifying Defaults changes it for every user of the package. Use DefaultConfig
+// instead.
 var Defaults = Config{}
 
-// op is used to internally store functions with names. In case of an undo operation, op stores the
-// undo function fn and the redo function redoFn. Used on the redo stack, however, the op only uses
-// fn to store the redo function and redoFn will be nil.
+// DefaultConfig returns a fresh copy of the default configuration of an UndoManager. Use it as a
+// starting point for modifications instead of an empty Config.
+func DefaultConfig() Config {
+	return Config{}
+}
+
+// valueFunc is the internal form of undo and redo functions. Functions passed to Add are wrapped
+// so that they yield a nil value.
+type valueFunc func(ctx context.Context) (any, error)
+
+// wrap turns an undo or redo function passed to Add into a valueFunc. A nil function stays nil.
+func wrap(fn func(ctx context.Context) error) valueFunc {
+	if fn == nil {
+		return nil
+	}
+	return func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	}
+}
+
+// op is used to internally store functions with names. An op stores the undo function fn and the
+// redo function redoFn, and the same op moves between the undo and the redo stack so that it can be
+// undone and redone any number of times. An op whose redoFn is nil cannot be redone and is dropped
+// once it has been undone.
 type op struct {
-	fn     func(ctx context.Context) error // the undo function
-	redoFn func(ctx context.Context) error // a function to redo the function that was undone
-	name   string                          // the name used in undo and redo templates
+	fn     valueFunc // the undo function
+	redoFn valueFunc // a function to redo the function that was undone
+	name   string    // the name used in undo and redo templates
+	group  *group    // the group state if the op is a group, nil otherwise
+	added  time.Time // when the op was added, kept when it is undone and redone
+	last   time.Time // when an op was last merged into this one, or added if there was none
+	strict bool      // true if the op must be rejected instead of evicting entries, see TryAdd
 }
 
 // UndoManager manages commands and provides undo/redo functionality.
+//
+// An UndoManager never holds its lock while calling undo and redo functions or subscribers, so
+// these may call methods of the UndoManager, e.g. CanUndo or UndoName to decide how to behave.
+// Adding operations from within them is queued as described for Add. The exceptions are Shutdown,
+// ShutdownWithTimeout and waiting on the channel returned by Idle: these wait until no undo or
+// redo function is running, including the one they are called from, so they deadlock or time out
+// when called from an undo or redo function, or from a subscriber notified during UndoAll,
+// RedoAll or GoTo. The only user code called under the lock is the configured Clock, which must
+// therefore not call the UndoManager.
+//
+// If an undo or redo function panics, the UndoManager handles the op as if the function had
+// returned an error before the panic continues, so a caller that recovers can keep using it.
 type UndoManager struct {
-	undoStack  []op            // holds undo operations (op.redoFn holds the redo function)
-	redoStack  []op            // holds redo operations (op.redoFn is nil)
-	config     Config          // the undo manager configuration
-	mutex      sync.RWMutex    // internal sync
-	wg         sync.WaitGroup  // for waiting until everything has finished
-	mainCtx    context.Context // the master context from which other contexts need to be derived
-	mainCancel func()          // the main cancel function that cancels all pending operations
+	undoStack   []op            // holds operations that can be undone
+	redoStack   []op            // holds operations that can be redone
+	config      Config          // the undo manager configuration
+	mutex       sync.RWMutex    // internal sync
+	wg          sync.WaitGroup  // for waiting until everything has finished
+	mainCtx     context.Context // the master context from which other contexts need to be derived
+	mainCancel  func()          // the main cancel function that cancels all pending operations
+	clock       Clock           // the source of time
+	events      *eventLog       // the event log, nil if disabled
+	dropped     int             // number of entries evicted from the bottom of the undo stack so far
+	groups      []*group        // the currently open groups, innermost last
+	savedPos    int             // absolute position marked by MarkSaved, -1 if it can't be reached
+	bookmarks   []bookmark      // named positions in order of creation
+	running     int             // number of undo and redo functions and bulk operations running
+	pending     []op            // ops added while undo or redo functions were running
+	generation  int             // incremented by clear, so running functions don't restore old ops
+	idle        chan struct{}   // closed when running drops to zero, nil if nobody waits
+	lastUndo    time.Time       // when Undo or Redo was last accepted by the rate limit
+	shutdown    bool            // true once Shutdown has been called, until Reset
+	subscribers []subscriber    // functions registered with Subscribe
+	nextSubID   int             // the id of the last subscriber
+	avail       [2]bool         // CanUndo and CanRedo at the last notification
 }
 
 // New returns a new, empty undo manager. undoMsg and redoMsg are fmt templates which
@@ -59,6 +146,13 @@ func New(config ...Config) (*UndoManager, error) {
 		undoStack: make([]op, 0),
 		redoStack: make([]op, 0),
 		config:    cfg,
+		clock:     cfg.Clock,
+	}
+	if mgr.clock == nil {
+		mgr.clock = realClock{}
+	}
+	if cfg.EventLogSize > 0 {
+		mgr.events = newEventLog(cfg.EventLogSize)
 	}
 	mgr.mainCtx, mgr.mainCancel = context.WithCancel(context.Background())
 	return mgr, nil
@@ -66,14 +160,111 @@ func New(config ...Config) (*UndoManager, error) {
 
 // WithCancel returns a cancelable context based on the UndoManager's master context.
 func (mgr *UndoManager) WithCancel() (context.Context, func()) {
-	return context.WithCancel(mgr.mainCtx)
+	return context.WithCancel(mgr.Context())
+}
+
+// WithValue returns a context carrying the given key and value that is canceled together with
+// the UndoManager's master context, so CancelAll and Shutdown still reach undo and redo functions
+// that receive it. The returned function cancels the context.
+func (mgr *UndoManager) WithValue(key, val any) (context.Context, func()) {
+	ctx, cancel := mgr.WithCancel()
+	return context.WithValue(ctx, key, val), cancel
 }
 
 // Context returns the cancelable master context.
 func (mgr *UndoManager) Context() context.Context {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
 	return mgr.mainCtx
 }
 
+// joinContext returns a context derived from ctx that is also canceled when the master context
+// is canceled, so CancelAll and Shutdown reach undo and redo functions whatever context the caller
+// passed. The returned function must be called to release the context.
+func (mgr *UndoManager) joinContext(ctx context.Context) (context.Context, func()) {
+	main := mgr.Context()
+	joined, cancel := context.WithCancel(ctx)
+	if main.Err() != nil {
+		cancel()
+		return joined, cancel
+	}
+	go func() {
+		select {
+		case <-main.Done():
+			cancel()
+		case <-joined.Done():
+		}
+	}()
+	return joined, cancel
+}
+
+// Clear removes all operations from the undo and redo stacks, e.g. when a document is closed.
+// Operations whose undo or redo function is running while Clear is called, and operations whose
+// Add was queued behind them, are discarded as well once the functions finish.
+func (mgr *UndoManager) Clear() {
+	mgr.mutex.Lock()
+	mgr.clear()
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseClear, "", nil)
+	notify(subscribers)
+}
+
+// clear empties both stacks, discards queued additions, and marks the resulting state as saved.
+// It starts a new generation, so ops popped by running undo and redo functions are dropped when
+// they finish. The mutex must be held.
+func (mgr *UndoManager) clear() {
+	mgr.dropped += len(mgr.undoStack)
+	mgr.undoStack = make([]op, 0)
+	mgr.redoStack = make([]op, 0)
+	mgr.pending = nil
+	mgr.savedPos = mgr.dropped
+	mgr.generation++
+}
+
+// position returns the number of operations that have been done so far, including evicted
+// ones. The mutex must be held.
+func (mgr *UndoManager) position() int {
+	return mgr.dropped + len(mgr.undoStack)
+}
+
+// MarkSaved records the current position as the clean state, e.g. after a document was saved.
+func (mgr *UndoManager) MarkSaved() {
+	mgr.mutex.Lock()
+	mgr.savedPos = mgr.position()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseMarkSaved, "", nil)
+}
+
+// IsModified returns true if the current position differs from the one recorded by the last
+// call to MarkSaved, false otherwise. A new manager and a manager that has just been cleared are
+// unmodified. Adding an operation after undoing past the saved position makes the saved state
+// unreachable, so the manager stays modified until MarkSaved is called again.
+func (mgr *UndoManager) IsModified() bool {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return mgr.position() != mgr.savedPos
+}
+
+// Reset clears the stacks like Clear and replaces the master context by a fresh one, so the
+// UndoManager can be reused after Shutdown. Open groups, queued additions, bookmarks and the state
+// of the rate limit are discarded. The old master context is canceled, and contexts obtained from
+// WithCancel afterwards derive from the new one.
+func (mgr *UndoManager) Reset() {
+	mgr.mutex.Lock()
+	mgr.clear()
+	mgr.groups = nil
+	mgr.bookmarks = nil
+	mgr.lastUndo = time.Time{}
+	mgr.shutdown = false
+	mgr.mainCancel()
+	mgr.mainCtx, mgr.mainCancel = context.WithCancel(context.Background())
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseReset, "", nil)
+	notify(subscribers)
+}
+
 // WGAdd adds n entries to the UndoManager's wait group.
 func (mgr *UndoManager) WGAdd(n int) {
 	mgr.wg.Add(n)
@@ -91,23 +282,418 @@ func (mgr *UndoManager) WaitAll() {
 	mgr.wg.Wait()
 }
 
-// Shutdown shuts down the op manager, waiting for all pending operations to finish.
-// If cancel is true, then running operations are canceled, otherwise the op manager
-// allows them to finish first. Operations should always make sure that they cancel
-// gracefully and as fast as possible.
+// Shutdown shuts down the op manager, waiting for all pending operations to finish. These are
+// the operations tracked by WGAdd as well as running undo and redo functions. If cancel is true,
+// then running operations are canceled, otherwise the op manager allows them to finish first.
+// Operations should always make sure that they cancel gracefully and as fast as possible. After
+// Shutdown, adding, undoing and redoing operations fails with ErrManagerClosed until Reset is
+// called. Shutdown must not be called from an undo or redo function, because it would wait for
+// that function to finish.
 func (mgr *UndoManager) Shutdown(cancel bool) {
+	mgr.markShutdown()
+	if cancel {
+		mgr.CancelAll()
+	}
+	mgr.waitFinished()
+}
+
+// ShutdownWithTimeout shuts down the undo manager like Shutdown but waits at most d for pending
+// operations to finish. If they don't finish in time, ErrShutdownTimeout is returned. When cancel
+// is true, running operations are canceled before waiting, even if the timeout is reached later.
+// Called from an undo or redo function, it always times out, because it waits for that function.
+func (mgr *UndoManager) ShutdownWithTimeout(cancel bool, d time.Duration) error {
+	mgr.markShutdown()
 	if cancel {
 		mgr.CancelAll()
 	}
+	done := make(chan struct{})
+	go func() {
+		mgr.waitFinished()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-mgr.clock.After(d):
+		return ErrShutdownTimeout
+	}
+}
+
+// waitFinished waits for the operations tracked by WGAdd and for running undo and redo functions.
+func (mgr *UndoManager) waitFinished() {
 	mgr.WaitAll()
+	<-mgr.Idle()
+}
+
+// markShutdown records that the UndoManager is shut down.
+func (mgr *UndoManager) markShutdown() {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.shutdown = true
+}
+
+// closed returns true if Shutdown has been called or the master context has been canceled, e.g.
+// by CancelAll. The mutex must be held.
+func (mgr *UndoManager) closed() bool {
+	return mgr.shutdown || mgr.mainCtx.Err() != nil
+}
+
+// add pushes an op and notifies subscribers. It returns ErrManagerClosed instead if the
+// UndoManager is closed.
+func (mgr *UndoManager) add(o op) error {
+	mgr.mutex.Lock()
+	if mgr.closed() {
+		mgr.mutex.Unlock()
+		mgr.logEvent(PhaseAdd, o.name, ErrManagerClosed)
+		return ErrManagerClosed
+	}
+	mgr.push(o)
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseAdd, o.name, nil)
+	notify(subscribers)
+	return nil
 }
 
-// Add adds an undo function to the UndoManager.
+// Add adds an undo function to the UndoManager. If the storage limit is reached, the oldest entry
+// is dropped to make room for the new one. Redo entries count towards the storage limit. If redoFn
+// is nil, the operation cannot be redone: it is dropped once undone together with the rest of the
+// redo stack, so CanRedo stays false. Adding an operation discards all operations that can
+// currently be redone.
+//
+// Add may be called from within an undo or redo function, e.g. for cascading edits. While an undo
+// or redo function is running, added functions are queued and only pushed onto the undo stack
+// once it has finished and the undone or redone entry has been moved to its new stack. During
+// UndoAll, RedoAll and GoTo, they are queued until the whole call has finished. Like any other
+// Add, pushing them discards the redo stack. This applies to Add calls from any goroutine, and to
+// AddV, TryAdd, AddBatch and EndGroup as well.
+//
+// Once the UndoManager has been shut down or its master context has been canceled, Add records
+// nothing and returns ErrManagerClosed, as do the other ways of adding operations.
 func (mgr *UndoManager) Add(name string, undoFn func(ctx context.Context) error,
-	redoFn func(ctx context.Context) error) {
+	redoFn func(ctx context.Context) error) error {
+	return mgr.add(op{name: name, fn: wrap(undoFn), redoFn: wrap(redoFn)})
+}
+
+// AddV adds an undo function to the UndoManager like Add, but its undo and redo functions return
+// a value, such as a restored object, which UndoV and RedoV pass on to the caller.
+func (mgr *UndoManager) AddV(name string, undoFn func(ctx context.Context) (any, error),
+	redoFn func(ctx context.Context) (any, error)) error {
+	return mgr.add(op{name: name, fn: undoFn, redoFn: redoFn})
+}
+
+// TryAdd adds an undo function to the UndoManager like Add, but returns a StorageError instead of
+// dropping the oldest entry when the storage limit or the undo limit is reached. An operation
+// that is merged into the top entry takes no room and is always accepted.
+//
+// Inside a group, the limits are checked when the outermost group ends: if the group does not fit,
+// EndGroup discards it and returns a StorageError with the name of the group. While undo or redo
+// functions are running, the operation is queued like with Add and checked once it is pushed; if
+// it does not fit then, it is discarded and the StorageError is only recorded in the event log.
+// In no case does TryAdd evict entries.
+func (mgr *UndoManager) TryAdd(name string, undoFn func(ctx context.Context) error,
+	redoFn func(ctx context.Context) error) error {
+	mgr.mutex.Lock()
+	if mgr.closed() {
+		mgr.mutex.Unlock()
+		mgr.logEvent(PhaseAdd, name, ErrManagerClosed)
+		return ErrManagerClosed
+	}
+	now := mgr.clock.Now()
+	o := op{name: name, fn: wrap(undoFn), redoFn: wrap(redoFn), added: now, last: now, strict: true}
+	if !mgr.push(o) {
+		mgr.mutex.Unlock()
+		err := StorageError{Name: name}
+		mgr.logEvent(PhaseAdd, name, err)
+		return err
+	}
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseAdd, name, nil)
+	notify(subscribers)
+	return nil
+}
+
+// Replace replaces the top entry of the undo stack by the given functions, e.g. to amend the
+// entry of a drag gesture with its final position instead of adding a new one. Like Add, it
+// discards the redo stack, and it invalidates the save marker and bookmarks that point at the
+// replaced entry or beyond. It returns false and does nothing if the undo stack is empty, if an
+// undo or redo function is running, because the top entry is then about to change, or if the
+// UndoManager has been shut down.
+func (mgr *UndoManager) Replace(name string, undoFn func(ctx context.Context) error,
+	redoFn func(ctx context.Context) error) bool {
+	mgr.mutex.Lock()
+	if mgr.closed() {
+		mgr.mutex.Unlock()
+		mgr.logEvent(PhaseReplace, name, ErrManagerClosed)
+		return false
+	}
+	if len(mgr.undoStack) == 0 || mgr.running > 0 {
+		mgr.mutex.Unlock()
+		return false
+	}
+	mgr.invalidateAfter(mgr.position() - 1)
+	mgr.redoStack = make([]op, 0)
+	now := mgr.clock.Now()
+	mgr.undoStack[len(mgr.undoStack)-1] = op{name: name, fn: wrap(undoFn), redoFn: wrap(redoFn),
+		added: now, last: now}
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	mgr.logEvent(PhaseReplace, name, nil)
+	notify(subscribers)
+	return true
+}
+
+// finishRunning marks an undo or redo function as finished. When no more functions are running,
+// the ops added in the meantime are pushed in the order in which they were added; ops added with
+// TryAdd that no longer fit are discarded and logged. The mutex must be held.
+func (mgr *UndoManager) finishRunning() {
+	mgr.running--
+	if mgr.running > 0 {
+		return
+	}
+	pending := mgr.pending
+	mgr.pending = nil
+	for _, o := range pending {
+		if !mgr.push(o) {
+			mgr.logEvent(PhaseAdd, o.name, StorageError{Name: o.name})
+		}
+	}
+	if mgr.idle != nil {
+		close(mgr.idle)
+		mgr.idle = nil
+	}
+}
+
+// hold marks a bulk operation such as UndoAll as running, so that operations added until the
+// matching call to release are queued.
+func (mgr *UndoManager) hold() {
 	mgr.mutex.Lock()
 	defer mgr.mutex.Unlock()
-	mgr.undoStack = append(mgr.undoStack, op{name: name, fn: undoFn, redoFn: redoFn})
+	mgr.running++
+}
+
+// release ends a bulk operation started by hold and pushes the queued operations once nothing
+// else is running.
+func (mgr *UndoManager) release() {
+	mgr.mutex.Lock()
+	mgr.finishRunning()
+	subscribers := mgr.observe()
+	mgr.mutex.Unlock()
+	notify(subscribers)
+}
+
+// Idle returns a channel that is closed once no undo or redo function, UndoAll, RedoAll or GoTo is
+// running and all operations added in the meantime have been pushed. If the UndoManager is idle
+// already, the returned channel is closed. Each call while busy returns a channel for the current
+// busy period, so call Idle again after the channel was closed to wait for later work. Operations
+// tracked only by WGAdd are not taken into account; use WaitAll for those.
+func (mgr *UndoManager) Idle() <-chan struct{} {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if mgr.running == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	if mgr.idle == nil {
+		mgr.idle = make(chan struct{})
+	}
+	return mgr.idle
+}
+
+// full returns true if adding another entry to the undo stack would exceed the storage limit
+// or the undo limit, so that push would reject a strict op. Adding discards the redo stack, so
+// only the undo stack is counted. The mutex must be held.
+func (mgr *UndoManager) full() bool {
+	if mgr.config.StorageLimit != UnlimitedStorage && len(mgr.undoStack) >= mgr.config.StorageLimit {
+		return true
+	}
+	return mgr.config.UndoLimit > 0 && len(mgr.undoStack) >= mgr.config.UndoLimit
+}
+
+// evict drops the oldest entries until n more entries fit onto the undo stack. For the undo limit,
+// entries are taken from the bottom of the undo stack. For the storage limit, they are taken from
+// the bottom of the undo stack first and from the bottom of the redo stack once the undo stack is
+// empty. The mutex must be held.
+func (mgr *UndoManager) evict(n int) {
+	if mgr.config.UndoLimit > 0 {
+		for len(mgr.undoStack) > 0 && len(mgr.undoStack)+n > mgr.config.UndoLimit {
+			mgr.dropUndo()
+		}
+	}
+	if mgr.config.StorageLimit == UnlimitedStorage {
+		return
+	}
+	for len(mgr.undoStack)+len(mgr.redoStack)+n > mgr.config.StorageLimit {
+		if len(mgr.undoStack) > 0 {
+			mgr.dropUndo()
+		} else if len(mgr.redoStack) > 0 {
+			mgr.redoStack = mgr.redoStack[1:]
+		} else {
+			return
+		}
+	}
+}
+
+// dropUndo drops the oldest entry of the undo stack. The mutex must be held.
+func (mgr *UndoManager) dropUndo() {
+	mgr.undoStack = mgr.undoStack[1:]
+	mgr.dropped++
+}
+
+// capRedo drops the oldest entries of the redo stack until it fits into the redo limit. The mutex
+// must be held.
+func (mgr *UndoManager) capRedo() {
+	if mgr.config.RedoLimit > 0 && len(mgr.redoStack) > mgr.config.RedoLimit {
+		mgr.redoStack = mgr.redoStack[len(mgr.redoStack)-mgr.config.RedoLimit:]
+	}
+}
+
+// Position returns the number of operations that can currently be undone, e.g. for a history
+// slider.
+func (mgr *UndoManager) Position() int {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return len(mgr.undoStack)
+}
+
+// GoTo undoes or redoes operations until exactly n operations can be undone. If n is negative or
+// larger than the number of operations on both stacks, ErrInvalidPosition is returned. If an undo
+// or redo function returns an error, GoTo stops and returns it; the operations undone or redone so
+// far stay that way and the failing operation stays on its stack. Operations added while GoTo
+// runs are queued and pushed once it has reached n, so afterwards more than n operations can be
+// undone.
+func (mgr *UndoManager) GoTo(ctx context.Context, n int) error {
+	err := mgr.goTo(ctx, n)
+	mgr.logEvent(PhaseGoTo, "", err)
+	return err
+}
+
+// goTo implements GoTo without logging.
+func (mgr *UndoManager) goTo(ctx context.Context, n int) error {
+	mgr.mutex.RLock()
+	total := len(mgr.undoStack) + len(mgr.redoStack)
+	mgr.mutex.RUnlock()
+	if n < 0 || n > total {
+		return ErrInvalidPosition
+	}
+	mgr.hold()
+	defer mgr.release()
+	for {
+		pos := mgr.Position()
+		var err error
+		switch {
+		case pos > n:
+			_, err = mgr.undo(ctx, true)
+		case pos < n:
+			_, err = mgr.redo(ctx, true)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Capacity returns the maximum number of entries counted by Used, or -1 if it is unlimited. This
+// is the smaller of the storage limit and the undo limit: entries only enter the redo stack from
+// the undo stack, and adding discards the redo stack, so both stacks together never hold more
+// entries than the undo limit. The redo limit alone does not bound the undo stack and is ignored.
+func (mgr *UndoManager) Capacity() int {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	capacity := -1
+	for _, limit := range []int{mgr.config.StorageLimit, mgr.config.UndoLimit} {
+		if limit > 0 && (capacity < 0 || limit < capacity) {
+			capacity = limit
+		}
+	}
+	return capacity
+}
+
+// Used returns the number of entries on the undo and redo stacks combined, which is the amount
+// of storage counted against the storage limit.
+func (mgr *UndoManager) Used() int {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return len(mgr.undoStack) + len(mgr.redoStack)
+}
+
+// UndoNames returns the names of the entries on the undo stack from newest to oldest. The result
+// is a copy and is empty but not nil if there is nothing to undo.
+func (mgr *UndoManager) UndoNames() []string {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return names(mgr.undoStack)
+}
+
+// RedoNames returns the names of the entries on the redo stack from newest to oldest, i.e. the
+// entry that Redo would redo first comes first. The result is a copy and is empty but not nil if
+// there is nothing to redo.
+func (mgr *UndoManager) RedoNames() []string {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	return names(mgr.redoStack)
+}
+
+// names returns the names of the ops in stack from top to bottom.
+func names(stack []op) []string {
+	result := make([]string, len(stack))
+	for i := range stack {
+		result[i] = stack[len(stack)-1-i].name
+	}
+	return result
+}
+
+// HistoryEntry describes an entry of the undo stack for history panels and audit logs.
+type HistoryEntry struct {
+	Name  string    // the name of the operation
+	Added time.Time // when the operation was added, according to the configured Clock
+}
+
+// Entries returns the entries of the undo stack from newest to oldest. The timestamp of an entry
+// is the time when it was added; undoing and redoing an operation keeps its original timestamp.
+func (mgr *UndoManager) Entries() []HistoryEntry {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	entries := make([]HistoryEntry, len(mgr.undoStack))
+	for i := range mgr.undoStack {
+		o := mgr.undoStack[len(mgr.undoStack)-1-i]
+		entries[i] = HistoryEntry{Name: o.name, Added: o.added}
+	}
+	return entries
+}
+
+// State is a consistent snapshot of the UndoManager's stacks, e.g. for updating menus.
+type State struct {
+	CanUndo   bool   // true if an operation can be undone
+	CanRedo   bool   // true if an operation can be redone
+	UndoName  string // the name of the operation to undo, "" if there is none
+	RedoName  string // the name of the operation to redo, "" if there is none
+	UndoCount int    // the number of operations that can be undone
+	RedoCount int    // the number of operations that can be redone
+}
+
+// State returns a snapshot of the stacks taken under a single lock, so the fields are consistent
+// with each other even while other goroutines modify the UndoManager.
+func (mgr *UndoManager) State() State {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+	st := State{
+		CanUndo:   len(mgr.undoStack) > 0,
+		CanRedo:   len(mgr.redoStack) > 0,
+		UndoCount: len(mgr.undoStack),
+		RedoCount: len(mgr.redoStack),
+	}
+	if st.CanUndo {
+		st.UndoName = mgr.undoStack[len(mgr.undoStack)-1].name
+	}
+	if st.CanRedo {
+		st.RedoName = mgr.redoStack[len(mgr.redoStack)-1].name
+	}
+	return st
 }
 
 // CanUndo returns true if an operation can be undone, false otherwise.
@@ -127,33 +713,106 @@ func (mgr *UndoManager) UndoName() string {
 	return mgr.undoStack[len(mgr.undoStack)-1].name
 }
 
-func (mgr *UndoManager) popUndo() (op, bool) {
+// popUndo pops the top of the undo stack and marks an undo or redo function as running. It also
+// returns the current generation. It returns ErrManagerClosed if the UndoManager is closed and
+// ErrCantUndo if the undo stack is empty.
+func (mgr *UndoManager) popUndo() (op, int, error) {
 	mgr.mutex.Lock()
 	defer mgr.mutex.Unlock()
+	if mgr.closed() {
+		return op{}, 0, ErrManagerClosed
+	}
 	if len(mgr.undoStack) == 0 {
-		return op{}, false
+		return op{}, 0, ErrCantUndo
 	}
 	undoOp := mgr.undoStack[len(mgr.undoStack)-1]
 	mgr.undoStack = mgr.undoStack[:len(mgr.undoStack)-1]
-	return undoOp, true
+	mgr.running++
+	return undoOp, mgr.generation, nil
 }
 
-// Undo the last operation added to the UndoManager. If no operation can be undone, ErrCantUndo is returned.
+// Undo the last operation added to the UndoManager. If no operation can be undone, ErrCantUndo is
+// returned, and if the UndoManager has been shut down or its master context has been canceled,
+// ErrManagerClosed is returned and the operation stays on the undo stack. The undo function
+// receives a context that is canceled when either ctx or the master context is canceled.
 func (mgr *UndoManager) Undo(ctx context.Context) error {
-	o, ok := mgr.popUndo()
-	if !ok {
-		return ErrCantUndo
-	}
-	err := o.fn(ctx)
-	if err != nil {
+	if err := mgr.limitRate(); err != nil {
 		return err
 	}
+	_, err := mgr.undo(ctx, false)
+	return err
+}
+
+// UndoV undoes the last operation like Undo and returns the value yielded by its undo function.
+// Undo functions added with Add yield nil.
+func (mgr *UndoManager) UndoV(ctx context.Context) (any, error) {
+	if err := mgr.limitRate(); err != nil {
+		return nil, err
+	}
+	return mgr.undo(ctx, false)
+}
+
+// limitRate returns ErrRateLimited if Undo or Redo was called less than Config.MinUndoInterval
+// ago, e.g. because the user holds down the undo key. Otherwise it records the current time.
+// UndoAll, RedoAll and GoTo are deliberate bulk operations and are not rate limited.
+func (mgr *UndoManager) limitRate() error {
+	if mgr.config.MinUndoInterval <= 0 {
+		return nil
+	}
 	mgr.mutex.Lock()
 	defer mgr.mutex.Unlock()
-	mgr.redoStack = append(mgr.redoStack, op{name: o.name, fn: o.redoFn})
+	now := mgr.clock.Now()
+	if !mgr.lastUndo.IsZero() && now.Sub(mgr.lastUndo) < mgr.config.MinUndoInterval {
+		return ErrRateLimited
+	}
+	mgr.lastUndo = now
+	return nil
+}
+
+// UndoAll undoes operations until the undo stack is empty or an undo function returns an error.
+// In case of an error, the operations undone so far stay undone, the failing operation stays on
+// the undo stack, and the error is returned. Operations added while UndoAll runs are queued and
+// pushed once it has finished, so they are not undone and may be left on the undo stack.
+func (mgr *UndoManager) UndoAll(ctx context.Context) error {
+	mgr.hold()
+	defer mgr.release()
+	for mgr.CanUndo() {
+		if _, err := mgr.undo(ctx, true); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// undo undoes the top of the undo stack. If the undo function fails or panics, the op is put back
+// on the undo stack when keepOnError is true or the op is a group, and dropped otherwise; see
+// forget. The state then still contains the op's effects, so the current position is invalidated
+// too. A successfully undone op without redo function is dropped as well. If the stacks were
+// cleared while the undo function ran, the op is dropped.
+func (mgr *UndoManager) undo(ctx context.Context, keepOnError bool) (any, error) {
+	o, gen, err := mgr.popUndo()
+	if err != nil {
+		mgr.logEvent(PhaseUndo, "", err)
+		return nil, err
+	}
+	return mgr.run(ctx, PhaseUndo, o.name, o.fn, func(err error) {
+		if gen != mgr.generation {
+			return
+		}
+		switch {
+		case err != nil && (keepOnError || o.group != nil):
+			mgr.undoStack = append(mgr.undoStack, o)
+		case err != nil:
+			mgr.forget(mgr.position() - 1)
+		case o.redoFn != nil:
+			mgr.redoStack = append(mgr.redoStack, o)
+			mgr.capRedo()
+		default:
+			mgr.forget(mgr.position())
+		}
+	})
+}
+
 // CanRedo returns true if an operation can be redone, false otherwise.
 func (mgr *UndoManager) CanRedo() bool {
 	mgr.mutex.RLock()
@@ -171,22 +830,120 @@ func (mgr *UndoManager) RedoName() string {
 	return mgr.redoStack[len(mgr.redoStack)-1].name
 }
 
-func (mgr *UndoManager) popRedo() (op, bool) {
+// popRedo pops the top of the redo stack and marks an undo or redo function as running. It also
+// returns the current generation. It returns ErrManagerClosed if the UndoManager is closed and
+// ErrCantRedo if the redo stack is empty.
+func (mgr *UndoManager) popRedo() (op, int, error) {
 	mgr.mutex.Lock()
 	defer mgr.mutex.Unlock()
+	if mgr.closed() {
+		return op{}, 0, ErrManagerClosed
+	}
 	if len(mgr.redoStack) == 0 {
-		return op{}, false
+		return op{}, 0, ErrCantRedo
 	}
 	redoOp := mgr.redoStack[len(mgr.redoStack)-1]
 	mgr.redoStack = mgr.redoStack[:len(mgr.redoStack)-1]
-	return redoOp, true
+	mgr.running++
+	return redoOp, mgr.generation, nil
 }
 
-// Redo the last operation added to the UndoManager. If no operation can be redone, ErrCantRedo is returned.
+// Redo the last operation that was undone. If no operation can be redone, ErrCantRedo is returned.
+// A successfully redone operation is put back on the undo stack. Like in Undo, ErrManagerClosed is
+// returned once the UndoManager is closed, and the redo function receives a context that is
+// canceled when either ctx or the master context is canceled.
 func (mgr *UndoManager) Redo(ctx context.Context) error {
-	op, ok := mgr.popRedo()
-	if !ok {
-		return ErrCantRedo
+	if err := mgr.limitRate(); err != nil {
+		return err
+	}
+	_, err := mgr.redo(ctx, false)
+	return err
+}
+
+// RedoV redoes the last undone operation like Redo and returns the value yielded by its redo
+// function. Redo functions added with Add yield nil.
+func (mgr *UndoManager) RedoV(ctx context.Context) (any, error) {
+	if err := mgr.limitRate(); err != nil {
+		return nil, err
 	}
-	return op.fn(ctx)
+	return mgr.redo(ctx, false)
+}
+
+// RedoAll redoes operations until the redo stack is empty or a redo function returns an error.
+// In case of an error, the operations redone so far stay redone, the failing operation stays on
+// the redo stack, and the error is returned. Operations added while RedoAll runs are queued and
+// pushed once it has finished, which discards whatever is left on the redo stack.
+func (mgr *UndoManager) RedoAll(ctx context.Context) error {
+	mgr.hold()
+	defer mgr.release()
+	for mgr.CanRedo() {
+		if _, err := mgr.redo(ctx, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redo redoes the top of the redo stack. If the redo function fails or panics, the op is put back
+// on the redo stack when keepOnError is true or the op is a group, and dropped otherwise; see
+// forget. If the stacks were cleared while the redo function ran, the op is dropped.
+func (mgr *UndoManager) redo(ctx context.Context, keepOnError bool) (any, error) {
+	o, gen, err := mgr.popRedo()
+	if err != nil {
+		mgr.logEvent(PhaseRedo, "", err)
+		return nil, err
+	}
+	fn := o.redoFn
+	if fn == nil {
+		fn = func(ctx context.Context) (any, error) {
+			return nil, ErrCantRedo
+		}
+	}
+	return mgr.run(ctx, PhaseRedo, o.name, fn, func(err error) {
+		if gen != mgr.generation {
+			return
+		}
+		switch {
+		case err != nil && (keepOnError || o.group != nil) && o.redoFn != nil:
+			mgr.redoStack = append(mgr.redoStack, o)
+		case err != nil:
+			mgr.forget(mgr.position())
+		default:
+			mgr.evict(1)
+			mgr.undoStack = append(mgr.undoStack, o)
+		}
+	})
+}
+
+// forget accounts for a popped op that is dropped instead of being moved to the other stack. The
+// history after the op is cut off: the redo stack is discarded, and the save marker and bookmarks
+// after pos are invalidated. The mutex must be held.
+func (mgr *UndoManager) forget(pos int) {
+	mgr.redoStack = make([]op, 0)
+	mgr.invalidateAfter(pos)
+}
+
+// run calls the undo or redo function fn of a popped op with a context that is also canceled by
+// the master context. Afterwards it logs the outcome, calls settle with the mutex held to move the
+// op to its new stack, marks the function as finished and notifies subscribers. This also happens
+// if fn panics, in which case settle receives errPanicked and the panic continues, so a caller that
+// recovers finds the UndoManager in a usable state. The value is only returned if fn succeeded.
+func (mgr *UndoManager) run(ctx context.Context, phase Phase, name string, fn valueFunc,
+	settle func(err error)) (result any, err error) {
+	err = errPanicked
+	defer func() {
+		if err != nil {
+			result = nil
+		}
+		mgr.logEvent(phase, name, err)
+		mgr.mutex.Lock()
+		settle(err)
+		mgr.finishRunning()
+		subscribers := mgr.observe()
+		mgr.mutex.Unlock()
+		notify(subscribers)
+	}()
+	ctx, cancel := mgr.joinContext(ctx)
+	defer cancel()
+	return fn(ctx)
 }
@@ -0,0 +1,946 @@
+package undo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rasteric/undo"
+	"github.com/rasteric/undo/undotest"
+)
+
+// nop is an undo or redo function that does nothing.
+func nop(ctx context.Context) error {
+	return nil
+}
+
+// newManager returns a new UndoManager with the given configuration and fails the test on error.
+func newManager(t *testing.T, config ...undo.Config) *undo.UndoManager {
+	t.Helper()
+	mgr, err := undo.New(config...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mgr
+}
+
+// mustAdd adds an operation that does nothing under the given name and fails the test on error.
+func mustAdd(t *testing.T, mgr *undo.UndoManager, name string) {
+	t.Helper()
+	if err := mgr.Add(name, nop, nop); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorageLimitEvictsOldest(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 3})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		mustAdd(t, mgr, name)
+	}
+	if got := mgr.Used(); got != 3 {
+		t.Errorf("Used() = %d, want 3", got)
+	}
+	assertNames(t, mgr.UndoNames(), "e", "d", "c")
+}
+
+func TestTryAddRejectsWhenFull(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 2})
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	err := mgr.TryAdd("c", nop, nop)
+	var serr undo.StorageError
+	if !errors.As(err, &serr) || serr.Name != "c" {
+		t.Fatalf("TryAdd() = %v, want StorageError for %q", err, "c")
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+}
+
+// assertNames fails the test if got differs from want.
+func assertNames(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got names %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got names %q, want %q", got, want)
+		}
+	}
+}
+
+func TestUndoRedoRoundTrip(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	value := 1
+	err := mgr.Add("set",
+		func(ctx context.Context) error { value = 0; return nil },
+		func(ctx context.Context) error { value = 1; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		do      func(context.Context) error
+		value   int
+		canUndo bool
+		canRedo bool
+	}{
+		{mgr.Undo, 0, false, true},
+		{mgr.Redo, 1, true, false},
+		{mgr.Undo, 0, false, true},
+	}
+	for i, step := range steps {
+		if err := step.do(ctx); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if value != step.value || mgr.CanUndo() != step.canUndo || mgr.CanRedo() != step.canRedo {
+			t.Errorf("step %d: value %d, CanUndo %v, CanRedo %v; want %d, %v, %v", i, value,
+				mgr.CanUndo(), mgr.CanRedo(), step.value, step.canUndo, step.canRedo)
+		}
+	}
+}
+
+func TestClearEmptiesBothStacks(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Clear()
+	if mgr.CanUndo() || mgr.CanRedo() {
+		t.Errorf("CanUndo() = %v, CanRedo() = %v after Clear, want false", mgr.CanUndo(), mgr.CanRedo())
+	}
+	if mgr.IsModified() {
+		t.Error("IsModified() = true after Clear, want false")
+	}
+}
+
+func TestClearDuringUndoDropsOp(t *testing.T) {
+	mgr := newManager(t)
+	err := mgr.Add("a", func(ctx context.Context) error {
+		mgr.Clear()
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.CanUndo() || mgr.CanRedo() {
+		t.Errorf("CanUndo() = %v, CanRedo() = %v, want the op cleared", mgr.CanUndo(), mgr.CanRedo())
+	}
+}
+
+func TestWithCancelAfterReset(t *testing.T) {
+	mgr := newManager(t)
+	mgr.Shutdown(true)
+	mgr.Bookmark("b")
+	mgr.Reset()
+	ctx, cancel := mgr.WithCancel()
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("context from WithCancel after Reset: %v", err)
+	}
+	mgr.CancelAll()
+	if ctx.Err() == nil {
+		t.Error("CancelAll did not cancel a context obtained after Reset")
+	}
+	if len(mgr.Bookmarks()) != 0 {
+		t.Errorf("Bookmarks() = %q after Reset, want none", mgr.Bookmarks())
+	}
+}
+
+func TestAddAfterUndoDiscardsRedo(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, mgr, "c")
+	if err := mgr.Redo(ctx); !errors.Is(err, undo.ErrCantRedo) {
+		t.Errorf("Redo() = %v, want ErrCantRedo", err)
+	}
+	assertNames(t, mgr.UndoNames(), "c", "a")
+}
+
+func TestUndoBelowSaveMarker(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	mgr.MarkSaved()
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.IsModified() {
+		t.Error("IsModified() = false after undoing below the marker, want true")
+	}
+	if err := mgr.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.IsModified() {
+		t.Error("IsModified() = true after redoing back to the marker, want false")
+	}
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, mgr, "c")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, mgr, "b")
+	if !mgr.IsModified() {
+		t.Error("IsModified() = false after replacing the saved history, want true")
+	}
+}
+
+func TestRedoAboveSaveMarker(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mgr.MarkSaved()
+	if err := mgr.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.IsModified() {
+		t.Error("IsModified() = false after redoing above the marker, want true")
+	}
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.IsModified() {
+		t.Error("IsModified() = true after undoing back to the marker, want false")
+	}
+}
+
+func TestUndoAllRedoAll(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	r := &recorder{}
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.UndoAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.CanUndo() || len(mgr.RedoNames()) != 3 {
+		t.Fatalf("UndoAll left %q to undo and %q to redo", mgr.UndoNames(), mgr.RedoNames())
+	}
+	if err := mgr.RedoAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.CanRedo() || len(mgr.UndoNames()) != 3 {
+		t.Fatalf("RedoAll left %q to undo and %q to redo", mgr.UndoNames(), mgr.RedoNames())
+	}
+	assertNames(t, r.calls, "undo c", "undo b", "undo a", "redo a", "redo b", "redo c")
+}
+
+func TestUndoAllRedoAllStopOnError(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	failure := errors.New("failure")
+	r := &recorder{fail: map[string]error{"undo b": failure, "redo c": failure}}
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.UndoAll(ctx); !errors.Is(err, failure) {
+		t.Fatalf("UndoAll() = %v, want %v", err, failure)
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+	assertNames(t, mgr.RedoNames(), "c")
+	if err := mgr.UndoAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.RedoAll(ctx); !errors.Is(err, failure) {
+		t.Fatalf("RedoAll() = %v, want %v", err, failure)
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+	assertNames(t, mgr.RedoNames(), "c")
+}
+
+// startUndo adds an operation with the given undo function, starts undoing it in a new goroutine,
+// and waits until the undo function is running. The returned channel receives Undo's result.
+func startUndo(t *testing.T, mgr *undo.UndoManager, ctx context.Context,
+	undoFn func(ctx context.Context) error) <-chan error {
+	t.Helper()
+	started := make(chan struct{})
+	err := mgr.Add("slow", func(ctx context.Context) error {
+		close(started)
+		return undoFn(ctx)
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- mgr.Undo(ctx)
+	}()
+	<-started
+	return result
+}
+
+func TestShutdownWaitsForRunningUndo(t *testing.T) {
+	mgr := newManager(t)
+	release := make(chan struct{})
+	finished := false
+	result := startUndo(t, mgr, context.Background(), func(ctx context.Context) error {
+		<-release
+		finished = true
+		return nil
+	})
+	go close(release)
+	mgr.Shutdown(false)
+	if !finished {
+		t.Error("Shutdown returned before the running undo function finished")
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Undo() = %v, want nil", err)
+	}
+}
+
+func TestShutdownWithTimeoutCooperativeUndo(t *testing.T) {
+	mgr := newManager(t)
+	result := startUndo(t, mgr, context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := mgr.ShutdownWithTimeout(true, time.Minute); err != nil {
+		t.Errorf("ShutdownWithTimeout() = %v, want nil", err)
+	}
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Undo() = %v, want context.Canceled", err)
+	}
+}
+
+func TestShutdownWithTimeoutStubbornUndo(t *testing.T) {
+	mgr := newManager(t)
+	release := make(chan struct{})
+	result := startUndo(t, mgr, context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if err := mgr.ShutdownWithTimeout(true, 10*time.Millisecond); !errors.Is(err, undo.ErrShutdownTimeout) {
+		t.Errorf("ShutdownWithTimeout() = %v, want ErrShutdownTimeout", err)
+	}
+	close(release)
+	<-result
+}
+
+func TestStateConsistentUnderConcurrentMutation(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 8})
+	ctx := context.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			if err := mgr.Add(strconv.Itoa(i), nop, nop); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%3 == 0 {
+				_ = mgr.Undo(ctx)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		st := mgr.State()
+		if st.CanUndo != (st.UndoCount > 0) || st.CanRedo != (st.RedoCount > 0) ||
+			(st.UndoName != "") != st.CanUndo || (st.RedoName != "") != st.CanRedo {
+			t.Fatalf("inconsistent state %+v", st)
+		}
+		if st.UndoCount+st.RedoCount > 8 {
+			t.Fatalf("state %+v exceeds the storage limit", st)
+		}
+	}
+}
+
+func TestMergeWindowExpires(t *testing.T) {
+	clock := undotest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	mgr := newManager(t, undo.Config{Clock: clock, MergeWindow: time.Second})
+	mustAdd(t, mgr, "type")
+	clock.Advance(500 * time.Millisecond)
+	mustAdd(t, mgr, "type")
+	clock.Advance(900 * time.Millisecond)
+	mustAdd(t, mgr, "type")
+	assertNames(t, mgr.UndoNames(), "type")
+	clock.Advance(time.Second + time.Millisecond)
+	mustAdd(t, mgr, "type")
+	assertNames(t, mgr.UndoNames(), "type", "type")
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	err := mgr.AddV("delete",
+		func(ctx context.Context) (any, error) { return "restored", nil },
+		func(ctx context.Context) (any, error) { return 42, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mgr.UndoV(ctx); err != nil || v != "restored" {
+		t.Errorf("UndoV() = %v, %v; want %q, nil", v, err, "restored")
+	}
+	if v, err := mgr.RedoV(ctx); err != nil || v != 42 {
+		t.Errorf("RedoV() = %v, %v; want 42, nil", v, err)
+	}
+	mustAdd(t, mgr, "plain")
+	if v, err := mgr.UndoV(ctx); err != nil || v != nil {
+		t.Errorf("UndoV() = %v, %v for an op added with Add; want nil, nil", v, err)
+	}
+}
+
+func TestUndoLimitAlone(t *testing.T) {
+	mgr := newManager(t, undo.Config{UndoLimit: 2})
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	assertNames(t, mgr.UndoNames(), "c", "b")
+	if err := mgr.UndoAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.RedoNames(), "b", "c")
+}
+
+func TestRedoLimitAlone(t *testing.T) {
+	mgr := newManager(t, undo.Config{RedoLimit: 2})
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	if err := mgr.UndoAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.RedoNames(), "a", "b")
+	if mgr.CanUndo() {
+		t.Errorf("UndoNames() = %q, want none", mgr.UndoNames())
+	}
+}
+
+func TestStorageLimitCountsRedo(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 3})
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	if err := mgr.GoTo(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.TryAdd("d", nop, nop); err != nil {
+		t.Fatalf("TryAdd() = %v, want room after discarding the redo stack", err)
+	}
+	mustAdd(t, mgr, "e")
+	mustAdd(t, mgr, "f")
+	assertNames(t, mgr.UndoNames(), "f", "e", "d")
+	if err := mgr.TryAdd("g", nop, nop); !errors.Is(err, undo.ErrOutOfMemory) {
+		t.Errorf("TryAdd() = %v, want ErrOutOfMemory", err)
+	}
+}
+
+func TestReplaceEmptyStack(t *testing.T) {
+	mgr := newManager(t)
+	if mgr.Replace("a", nop, nop) {
+		t.Error("Replace() = true on an empty stack, want false")
+	}
+	if mgr.CanUndo() {
+		t.Errorf("Replace added %q", mgr.UndoNames())
+	}
+}
+
+func TestReplaceTopEntry(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	r := &recorder{}
+	r.add(t, mgr, "a")
+	r.add(t, mgr, "drag")
+	mgr.MarkSaved()
+	if !mgr.Replace("drop", r.fn("undo drop"), r.fn("redo drop")) {
+		t.Fatal("Replace() = false, want true")
+	}
+	assertNames(t, mgr.UndoNames(), "drop", "a")
+	if !mgr.IsModified() {
+		t.Error("IsModified() = false after replacing the saved entry, want true")
+	}
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo drop")
+}
+
+func TestReplaceRefusedWhileRunningOrClosed(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	var replaced bool
+	err := mgr.Add("b", func(ctx context.Context) error {
+		replaced = mgr.Replace("c", nop, nop)
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replaced {
+		t.Error("Replace() = true during an undo, want false")
+	}
+	assertNames(t, mgr.UndoNames(), "a")
+	mgr.Shutdown(false)
+	if mgr.Replace("c", nop, nop) {
+		t.Error("Replace() = true after Shutdown, want false")
+	}
+}
+
+func TestUndoAllDoesNotUndoCascadingAdds(t *testing.T) {
+	mgr := newManager(t)
+	r := &recorder{}
+	r.add(t, mgr, "a")
+	err := mgr.Add("b", func(ctx context.Context) error {
+		r.calls = append(r.calls, "undo b")
+		r.add(t, mgr, "cascade")
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.UndoAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo b", "undo a")
+	assertNames(t, mgr.UndoNames(), "cascade")
+	if mgr.CanRedo() {
+		t.Errorf("RedoNames() = %q after a cascading Add, want none", mgr.RedoNames())
+	}
+}
+
+func TestGoToTargetFixedDuringCascadingAdds(t *testing.T) {
+	mgr := newManager(t)
+	r := &recorder{}
+	r.add(t, mgr, "a")
+	err := mgr.Add("b", func(ctx context.Context) error {
+		r.add(t, mgr, "cascade")
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.add(t, mgr, "c")
+	if err := mgr.GoTo(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, r.calls, "undo c")
+	assertNames(t, mgr.UndoNames(), "cascade", "a")
+}
+
+func TestCancelAllCancelsWithValueContext(t *testing.T) {
+	type key struct{}
+	mgr := newManager(t)
+	ctx, cancel := mgr.WithValue(key{}, "v")
+	defer cancel()
+	if ctx.Value(key{}) != "v" {
+		t.Fatalf("Value() = %v, want %q", ctx.Value(key{}), "v")
+	}
+	mgr.CancelAll()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("Err() = %v after CancelAll, want context.Canceled", ctx.Err())
+	}
+}
+
+func TestCapacityAndUsed(t *testing.T) {
+	if got := newManager(t).Capacity(); got != -1 {
+		t.Errorf("Capacity() = %d for unlimited storage, want -1", got)
+	}
+	mgr := newManager(t, undo.Config{StorageLimit: 3})
+	if mgr.Capacity() != 3 || mgr.Used() != 0 {
+		t.Errorf("Capacity() = %d, Used() = %d when empty; want 3, 0", mgr.Capacity(), mgr.Used())
+	}
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := mgr.Used(); got != 2 {
+		t.Errorf("Used() = %d with one entry on each stack, want 2", got)
+	}
+	for _, name := range []string{"c", "d", "e"} {
+		mustAdd(t, mgr, name)
+	}
+	if mgr.Capacity() != 3 || mgr.Used() != 3 {
+		t.Errorf("Capacity() = %d, Used() = %d when full; want 3, 3", mgr.Capacity(), mgr.Used())
+	}
+}
+
+func TestEntriesTimestamps(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := undotest.NewFakeClock(start)
+	mgr := newManager(t, undo.Config{Clock: clock})
+	mustAdd(t, mgr, "a")
+	clock.Advance(time.Second)
+	mustAdd(t, mgr, "b")
+	clock.Advance(time.Second)
+	ctx := context.Background()
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Redo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entries := mgr.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Name != "b" || !entries[0].Added.Equal(start.Add(time.Second)) {
+		t.Errorf("newest entry %+v, want b added at %v", entries[0], start.Add(time.Second))
+	}
+	if entries[1].Name != "a" || !entries[1].Added.Equal(start) {
+		t.Errorf("oldest entry %+v, want a added at %v", entries[1], start)
+	}
+}
+
+func TestGoToBackwardAndForward(t *testing.T) {
+	mgr := newManager(t)
+	ctx := context.Background()
+	r := &recorder{}
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.GoTo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Position() != 1 {
+		t.Errorf("Position() = %d after seeking backward, want 1", mgr.Position())
+	}
+	if err := mgr.GoTo(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Position() != 3 {
+		t.Errorf("Position() = %d after seeking forward, want 3", mgr.Position())
+	}
+	assertNames(t, r.calls, "undo c", "undo b", "redo b", "redo c")
+	if err := mgr.GoTo(ctx, 4); !errors.Is(err, undo.ErrInvalidPosition) {
+		t.Errorf("GoTo(4) = %v, want ErrInvalidPosition", err)
+	}
+}
+
+func TestGoToStopsOnError(t *testing.T) {
+	mgr := newManager(t)
+	failure := errors.New("failure")
+	r := &recorder{fail: map[string]error{"undo b": failure}}
+	for _, name := range []string{"a", "b", "c"} {
+		r.add(t, mgr, name)
+	}
+	if err := mgr.GoTo(context.Background(), 0); !errors.Is(err, failure) {
+		t.Fatalf("GoTo() = %v, want %v", err, failure)
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+	assertNames(t, mgr.RedoNames(), "c")
+}
+
+func TestTryAddMergesWhenFull(t *testing.T) {
+	clock := undotest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	mgr := newManager(t, undo.Config{Clock: clock, StorageLimit: 1, MergeWindow: time.Second})
+	if err := mgr.TryAdd("type", nop, nop); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if err := mgr.TryAdd("type", nop, nop); err != nil {
+		t.Errorf("TryAdd() = %v inside the merge window, want nil", err)
+	}
+	clock.Advance(2 * time.Second)
+	if err := mgr.TryAdd("type", nop, nop); !errors.Is(err, undo.ErrOutOfMemory) {
+		t.Errorf("TryAdd() = %v outside the merge window, want ErrOutOfMemory", err)
+	}
+	assertNames(t, mgr.UndoNames(), "type")
+}
+
+func TestNewUsesDefaults(t *testing.T) {
+	saved := undo.Defaults
+	defer func() { undo.Defaults = saved }()
+	undo.Defaults.StorageLimit = 1
+	mgr := newManager(t)
+	if got := mgr.Capacity(); got != 1 {
+		t.Errorf("Capacity() = %d, want the StorageLimit of Defaults", got)
+	}
+}
+
+func TestDefaultConfigIsACopy(t *testing.T) {
+	cfg := undo.DefaultConfig()
+	cfg.StorageLimit = 1
+	if undo.DefaultConfig().StorageLimit != undo.UnlimitedStorage {
+		t.Error("modifying the result of DefaultConfig changed later results")
+	}
+	if got := newManager(t).Capacity(); got != -1 {
+		t.Errorf("Capacity() = %d, want -1", got)
+	}
+}
+
+func TestNewRejectsSeveralConfigs(t *testing.T) {
+	if _, err := undo.New(undo.Config{}, undo.Config{}); !errors.Is(err, undo.ErrTooManyConfig) {
+		t.Errorf("New() = %v, want ErrTooManyConfig", err)
+	}
+}
+
+func TestCancelAllAbortsSlowUndo(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	result := startUndo(t, mgr, context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	mgr.CancelAll()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Undo() = %v, want context.Canceled", err)
+	}
+	if err := mgr.Undo(context.Background()); !errors.Is(err, undo.ErrManagerClosed) {
+		t.Errorf("Undo() = %v after CancelAll, want ErrManagerClosed", err)
+	}
+	assertNames(t, mgr.UndoNames(), "a")
+}
+
+func TestUndoNamesAndRedoNames(t *testing.T) {
+	mgr := newManager(t)
+	for _, names := range [][]string{mgr.UndoNames(), mgr.RedoNames()} {
+		if names == nil || len(names) != 0 {
+			t.Errorf("got %v on an empty manager, want an empty non-nil slice", names)
+		}
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		mustAdd(t, mgr, name)
+	}
+	if err := mgr.GoTo(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+	assertNames(t, mgr.RedoNames(), "c", "d")
+}
+
+func TestUndoWithoutRedoFunction(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	if err := mgr.Add("irreversible", nop, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.CanRedo() {
+		t.Errorf("CanRedo() = true after undoing an op without redo function, want false")
+	}
+	assertNames(t, mgr.UndoNames(), "a")
+}
+
+func TestStorageErrorMatching(t *testing.T) {
+	mgr := newManager(t, undo.Config{UndoLimit: 1})
+	mustAdd(t, mgr, "a")
+	err := fmt.Errorf("saving: %w", mgr.TryAdd("b", nop, nop))
+	if !errors.Is(err, undo.ErrOutOfMemory) {
+		t.Errorf("errors.Is(%v, ErrOutOfMemory) = false, want true", err)
+	}
+	var serr undo.StorageError
+	if !errors.As(err, &serr) || serr.Name != "b" {
+		t.Errorf("errors.As(%v) gave %+v, want StorageError for %q", err, serr, "b")
+	}
+	if errors.Is(err, undo.ErrCantUndo) {
+		t.Errorf("errors.Is(%v, ErrCantUndo) = true, want false", err)
+	}
+}
+
+func TestReadStateFromUndoFunction(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "a")
+	var canUndo, canRedo bool
+	var name string
+	err := mgr.Add("b", func(ctx context.Context) error {
+		canUndo, canRedo, name = mgr.CanUndo(), mgr.CanRedo(), mgr.UndoName()
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- mgr.Undo(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Undo deadlocked when its undo function called CanUndo")
+	}
+	if !canUndo || canRedo || name != "a" {
+		t.Errorf("inside the undo function: CanUndo %v, CanRedo %v, UndoName %q; want true, false, %q",
+			canUndo, canRedo, name, "a")
+	}
+}
+
+func TestIdleAfterRunningWork(t *testing.T) {
+	mgr := newManager(t)
+	select {
+	case <-mgr.Idle():
+	default:
+		t.Fatal("Idle() is not closed on an idle manager")
+	}
+	release := make(chan struct{})
+	result := startUndo(t, mgr, context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	idle := mgr.Idle()
+	select {
+	case <-idle:
+		t.Fatal("Idle() closed while an undo function is running")
+	default:
+	}
+	close(release)
+	<-idle
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.CanRedo() {
+		t.Error("CanRedo() = false once idle, want the undone op on the redo stack")
+	}
+}
+
+func TestMinUndoInterval(t *testing.T) {
+	clock := undotest.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	mgr := newManager(t, undo.Config{Clock: clock, MinUndoInterval: 100 * time.Millisecond})
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	if err := mgr.Undo(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Millisecond)
+	if err := mgr.Undo(ctx); !errors.Is(err, undo.ErrRateLimited) {
+		t.Errorf("Undo() = %v for a rapid call, want ErrRateLimited", err)
+	}
+	if err := mgr.Redo(ctx); !errors.Is(err, undo.ErrRateLimited) {
+		t.Errorf("Redo() = %v for a rapid call, want ErrRateLimited", err)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if err := mgr.Undo(ctx); err != nil {
+		t.Errorf("Undo() = %v for a spaced call, want nil", err)
+	}
+	assertNames(t, mgr.UndoNames(), "a")
+}
+
+func TestAddAfterShutdown(t *testing.T) {
+	mgr := newManager(t)
+	mustAdd(t, mgr, "before")
+	mgr.Shutdown(false)
+	if err := mgr.Add("after", nop, nop); !errors.Is(err, undo.ErrManagerClosed) {
+		t.Errorf("Add() = %v after Shutdown, want ErrManagerClosed", err)
+	}
+	assertNames(t, mgr.UndoNames(), "before")
+	mgr.Reset()
+	mustAdd(t, mgr, "reset")
+}
+
+func TestTryAddInsideGroupNeverEvicts(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 2})
+	mustAdd(t, mgr, "a")
+	mustAdd(t, mgr, "b")
+	mgr.BeginGroup("g")
+	if err := mgr.TryAdd("c", nop, nop); err != nil {
+		t.Fatalf("TryAdd() = %v inside a group, want nil", err)
+	}
+	var serr undo.StorageError
+	if err := mgr.EndGroup(); !errors.As(err, &serr) || serr.Name != "g" {
+		t.Fatalf("EndGroup() = %v, want StorageError for %q", err, "g")
+	}
+	assertNames(t, mgr.UndoNames(), "b", "a")
+}
+
+func TestQueuedTryAddNeverEvicts(t *testing.T) {
+	mgr := newManager(t, undo.Config{StorageLimit: 2, EventLogSize: 16})
+	mustAdd(t, mgr, "a")
+	err := mgr.Add("b", func(ctx context.Context) error {
+		for _, name := range []string{"c", "d"} {
+			if err := mgr.TryAdd(name, nop, nop); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertNames(t, mgr.UndoNames(), "c", "a")
+	entries := mgr.DumpEventLog()
+	last := entries[len(entries)-1]
+	if !errors.Is(last.Err, undo.ErrOutOfMemory) || last.Name != "d" {
+		t.Errorf("last log entry %+v, want a StorageError for %q", last, "d")
+	}
+}
+
+func TestRecoveredPanicLeavesManagerUsable(t *testing.T) {
+	mgr := newManager(t)
+	err := mgr.Add("a", func(ctx context.Context) error {
+		panic("broken undo function")
+	}, nop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Undo did not pass on the panic")
+			}
+		}()
+		_ = mgr.Undo(context.Background())
+	}()
+	select {
+	case <-mgr.Idle():
+	default:
+		t.Fatal("Idle() is not closed after a recovered panic")
+	}
+	mustAdd(t, mgr, "b")
+	assertNames(t, mgr.UndoNames(), "b")
+	if err := mgr.ShutdownWithTimeout(false, time.Second); err != nil {
+		t.Errorf("ShutdownWithTimeout() = %v after a recovered panic, want nil", err)
+	}
+}
+
+func TestCapacityWithUndoAndRedoLimits(t *testing.T) {
+	for _, test := range []struct {
+		config undo.Config
+		want   int
+	}{
+		{undo.Config{UndoLimit: 100}, 100},
+		{undo.Config{StorageLimit: 50, UndoLimit: 100}, 50},
+		{undo.Config{StorageLimit: 100, UndoLimit: 50}, 50},
+		{undo.Config{RedoLimit: 10}, -1},
+	} {
+		if got := newManager(t, test.config).Capacity(); got != test.want {
+			t.Errorf("Capacity() = %d for %+v, want %d", got, test.config, test.want)
+		}
+	}
+	mgr := newManager(t, undo.Config{UndoLimit: 2})
+	for _, name := range []string{"a", "b", "c"} {
+		mustAdd(t, mgr, name)
+	}
+	if err := mgr.Undo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.Used() != 2 || mgr.Capacity() != 2 {
+		t.Errorf("Used() = %d, Capacity() = %d at the undo limit; want 2, 2", mgr.Used(), mgr.Capacity())
+	}
+}
@@ -0,0 +1,71 @@
+// Package undotest provides helpers for testing code that uses the undo package.
+package undotest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a manually advanced implementation of undo.Clock. Time only moves when Advance or
+// Set is called, which makes time-based behavior deterministic in tests.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a pending channel returned by After.
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFakeClock returns a fake clock set to the given time.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the fake clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock has been advanced by at
+// least d. If d is not positive, the channel receives the current time immediately.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the fake clock forward by d and fires all channels whose deadline has passed.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	t := c.now.Add(d)
+	c.mutex.Unlock()
+	c.Set(t)
+}
+
+// Set sets the fake clock to t and fires all channels whose deadline has passed.
+func (c *FakeClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = t
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.deadline.After(t) {
+			w.ch <- t
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+}
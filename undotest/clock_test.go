@@ -0,0 +1,55 @@
+package undotest
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeClockNowMovesOnlyWhenAdvanced(t *testing.T) {
+	c := NewFakeClock(epoch)
+	if !c.Now().Equal(epoch) {
+		t.Fatalf("Now() = %v, want %v", c.Now(), epoch)
+	}
+	c.Advance(time.Second)
+	if want := epoch.Add(time.Second); !c.Now().Equal(want) {
+		t.Errorf("Now() = %v after Advance, want %v", c.Now(), want)
+	}
+	c.Set(epoch)
+	if !c.Now().Equal(epoch) {
+		t.Errorf("Now() = %v after Set, want %v", c.Now(), epoch)
+	}
+}
+
+func TestFakeClockAfterFiresAtDeadline(t *testing.T) {
+	c := NewFakeClock(epoch)
+	ch := c.After(time.Minute)
+	c.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("After fired before its deadline")
+	default:
+	}
+	c.Advance(time.Second)
+	select {
+	case got := <-ch:
+		if want := epoch.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("After delivered %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("After did not fire at its deadline")
+	}
+}
+
+func TestFakeClockAfterNonPositiveFiresImmediately(t *testing.T) {
+	c := NewFakeClock(epoch)
+	select {
+	case got := <-c.After(0):
+		if !got.Equal(epoch) {
+			t.Errorf("After(0) delivered %v, want %v", got, epoch)
+		}
+	default:
+		t.Fatal("After(0) did not fire immediately")
+	}
+}